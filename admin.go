@@ -0,0 +1,149 @@
+package main
+
+// admin.go implements a small typed client for the yggdrasil-go admin
+// socket. Older versions of yggpub hand-marshalled requests and walked
+// the decoded JSON as map[string]interface{}. Since yggdrasil-go now
+// exposes its admin request/response schemas as proper Go structs in
+// github.com/yggdrasil-network/yggdrasil-go/src/admin, we decode
+// straight into those instead.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
+)
+
+// adminCallTimeout bounds every admin socket round trip. Without it, a
+// yggdrasil instance that accepts the connection but never answers (or
+// answers slowly) wedges whichever poller is mid-call forever -- the
+// very problem the background-polled snapshot cache exists to keep
+// away from HTTP requests.
+const adminCallTimeout = 10 * time.Second
+
+// AdminClient talks to a running yggdrasil-go instance over its admin
+// socket, dialling fresh for every request the way yggdrasilctl does.
+type AdminClient struct {
+	addr *url.URL
+}
+
+// NewAdminClient parses addr, e.g. "unix:///var/run/yggdrasil.sock" or
+// "tcp://localhost:9001", and returns a client for it.
+func NewAdminClient(addr string) (*AdminClient, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("parse admin address: %w", err)
+	}
+	return &AdminClient{addr: u}, nil
+}
+
+func (c *AdminClient) dial() (net.Conn, error) {
+	if c.addr.Scheme == "unix" {
+		return net.Dial("unix", c.addr.Path)
+	}
+	return net.Dial(c.addr.Scheme, c.addr.Host)
+}
+
+// adminResponseEnvelope mirrors the response wire format used by the
+// yggdrasil-go admin socket: a status, an optional error, and an
+// embedded, request-specific payload.
+type adminResponseEnvelope struct {
+	Status   string          `json:"status"`
+	Error    string          `json:"error"`
+	Response json.RawMessage `json:"response"`
+}
+
+// call sends a named request with no extra fields and decodes the
+// "response" payload into v.
+func (c *AdminClient) call(name string, v interface{}) error {
+	conn, err := c.dial()
+	if err != nil {
+		return fmt.Errorf("dial admin socket: %w", err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(adminCallTimeout)); err != nil {
+		return fmt.Errorf("set admin socket deadline: %w", err)
+	}
+
+	req, err := json.Marshal(map[string]string{"request": name})
+	if err != nil {
+		return fmt.Errorf("marshal %s request: %w", name, err)
+	}
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("write %s request: %w", name, err)
+	}
+
+	buff := make([]byte, 65535)
+	n, err := conn.Read(buff)
+	if err != nil {
+		return fmt.Errorf("read %s response: %w", name, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("no response from admin socket for %s", name)
+	}
+
+	var env adminResponseEnvelope
+	if err := json.Unmarshal(buff[:n], &env); err != nil {
+		return fmt.Errorf("unmarshal %s response: %w", name, err)
+	}
+	if env.Status != "success" {
+		if env.Error != "" {
+			return fmt.Errorf("%s: %s", name, env.Error)
+		}
+		return fmt.Errorf("%s: non-successful response", name)
+	}
+	if v == nil || len(env.Response) == 0 {
+		return nil
+	}
+	return json.Unmarshal(env.Response, v)
+}
+
+// GetPeers returns the set of directly connected switch peers.
+func (c *AdminClient) GetPeers() (*admin.GetPeersResponse, error) {
+	var resp admin.GetPeersResponse
+	if err := c.call("getPeers", &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetSelf returns information about the local node.
+func (c *AdminClient) GetSelf() (*admin.GetSelfResponse, error) {
+	var resp admin.GetSelfResponse
+	if err := c.call("getSelf", &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetTree returns the local node's view of the spanning tree. This
+// replaced the old getDHT call when yggdrasil-go's switch/tree refactor
+// dropped the DHT in favour of tree-based routing.
+func (c *AdminClient) GetTree() (*admin.GetTreeResponse, error) {
+	var resp admin.GetTreeResponse
+	if err := c.call("getTree", &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetSessions returns the set of active sessions.
+func (c *AdminClient) GetSessions() (*admin.GetSessionsResponse, error) {
+	var resp admin.GetSessionsResponse
+	if err := c.call("getSessions", &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetPaths returns the set of known paths.
+func (c *AdminClient) GetPaths() (*admin.GetPathsResponse, error) {
+	var resp admin.GetPathsResponse
+	if err := c.call("getPaths", &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+// api.go exposes the same Snapshot the HTML template renders as a JSON
+// API, so dashboards and other tooling can consume yggpub without
+// scraping HTML.
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// wantsJSON decides whether a request to "/" should be served the JSON
+// API representation instead of the HTML page, based on its Accept
+// header.
+func wantsJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+// serveJSON encodes v as the response body, honouring If-None-Match
+// against etag so unchanged snapshots cost the client nothing.
+func serveJSON(w http.ResponseWriter, r *http.Request, v interface{}, etag string) {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// statusResponse is the payload for /api/v1/status: a small summary
+// rather than the full snapshot, for callers that just want a health
+// overview of the node.
+type statusResponse struct {
+	NodeName  string `json:"node_name"`
+	PeerCount int    `json:"peer_count"`
+	TreeSize  int    `json:"tree_size"`
+	PathCount int    `json:"path_count"`
+}
+
+// snapshotOrError reads the cached snapshot and writes an appropriate
+// HTTP error if it isn't usable yet, so every API handler fails the
+// same way. A poll error alone doesn't fail the request as long as a
+// previously-polled snapshot is still cached; see refreshSnapshot.
+func snapshotOrError(w http.ResponseWriter) (*Snapshot, bool) {
+	snap, _, err := currentSnapshot()
+	if snap == nil {
+		msg := "no snapshot available"
+		if err != nil {
+			msg = err.Error()
+		}
+		http.Error(w, msg, http.StatusServiceUnavailable)
+		return nil, false
+	}
+	return snap, true
+}
+
+func apiPeersHandler(w http.ResponseWriter, r *http.Request) {
+	snap, ok := snapshotOrError(w)
+	if !ok {
+		return
+	}
+	serveJSON(w, r, snap.Peers, snap.ETag())
+}
+
+func apiSelfHandler(w http.ResponseWriter, r *http.Request) {
+	snap, ok := snapshotOrError(w)
+	if !ok {
+		return
+	}
+	serveJSON(w, r, snap.Self, snap.ETag())
+}
+
+func apiTreeHandler(w http.ResponseWriter, r *http.Request) {
+	snap, ok := snapshotOrError(w)
+	if !ok {
+		return
+	}
+	serveJSON(w, r, snap.Tree, snap.ETag())
+}
+
+func apiPathsHandler(w http.ResponseWriter, r *http.Request) {
+	snap, ok := snapshotOrError(w)
+	if !ok {
+		return
+	}
+	serveJSON(w, r, snap.Paths, snap.ETag())
+}
+
+func apiStatusHandler(w http.ResponseWriter, r *http.Request) {
+	snap, ok := snapshotOrError(w)
+	if !ok {
+		return
+	}
+	status := statusResponse{
+		NodeName:  *nodename,
+		PeerCount: len(snap.Peers.Peers),
+		TreeSize:  len(snap.Tree.Tree),
+		PathCount: len(snap.Paths.Paths),
+	}
+	serveJSON(w, r, status, snap.ETag())
+}
+
+// parseRange parses the "range" query parameter used by the history
+// endpoints, e.g. "1h" or "30m", defaulting to 1 hour.
+func parseRange(s string) (time.Duration, error) {
+	if s == "" {
+		return time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// apiPeerHistoryHandler serves /api/v1/peers/{pubkey}/history.
+func apiPeerHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/v1/peers/")
+	pubkey := strings.TrimSuffix(rest, "/history")
+	if pubkey == "" || pubkey == rest {
+		http.NotFound(w, r)
+		return
+	}
+
+	span, err := parseRange(r.URL.Query().Get("range"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	serveJSON(w, r, history.Series(pubkey, time.Now().Add(-span)), "")
+}
+
+// apiHistoryHandler serves /api/v1/history: aggregate throughput across
+// every peer.
+func apiHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	span, err := parseRange(r.URL.Query().Get("range"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	serveJSON(w, r, history.TotalSeries(time.Now().Add(-span)), "")
+}
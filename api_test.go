@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWantsJSON(t *testing.T) {
+	cases := []struct {
+		accept string
+		want   bool
+	}{
+		{"application/json", true},
+		{"application/json, text/html", false},
+		{"text/html", false},
+		{"", false},
+		{"*/*", false},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept", c.accept)
+		if got := wantsJSON(r); got != c.want {
+			t.Errorf("wantsJSON(Accept=%q) = %v, want %v", c.accept, got, c.want)
+		}
+	}
+}
+
+func TestServeJSONSetsETagAndBody(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+
+	serveJSON(w, r, map[string]string{"hello": "world"}, `"abc123"`)
+
+	if got := w.Header().Get("ETag"); got != `"abc123"` {
+		t.Errorf("ETag header = %q, want %q", got, `"abc123"`)
+	}
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if body := w.Body.String(); body != "{\"hello\":\"world\"}\n" {
+		t.Errorf("body = %q, want %q", body, "{\"hello\":\"world\"}\n")
+	}
+}
+
+func TestServeJSONHonoursIfNoneMatch(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", `"abc123"`)
+	w := httptest.NewRecorder()
+
+	serveJSON(w, r, map[string]string{"hello": "world"}, `"abc123"`)
+
+	if w.Code != http.StatusNotModified {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotModified)
+	}
+}
+
+func TestParseRangeDefaultsToOneHour(t *testing.T) {
+	d, err := parseRange("")
+	if err != nil {
+		t.Fatalf("parseRange(\"\") returned error: %v", err)
+	}
+	if d.String() != "1h0m0s" {
+		t.Errorf("parseRange(\"\") = %v, want 1h0m0s", d)
+	}
+}
+
+func TestParseRangeRejectsGarbage(t *testing.T) {
+	if _, err := parseRange("not-a-duration"); err == nil {
+		t.Fatal("expected an error for an unparseable range")
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+// federation.go lets independent yggpub instances discover each other
+// over Yggdrasil, in the spirit of the peer-exchange pattern used by
+// other Yggdrasil tooling: each instance periodically announces itself
+// to a list of seed instances and pulls their registries, building up a
+// shared picture of known nodes at /nodes and /api/v1/nodes.
+//
+// Announcements are signed with an ed25519 keypair that yggpub
+// generates and persists for itself on first run. The admin socket has
+// no way to hand us the running yggdrasil node's own private key (nor
+// should it), so this key is yggpub's federation identity rather than
+// the node's transport key. Registry entries are keyed by it, so only
+// its holder can update its entry; nothing ties the key to a node name
+// or address.
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// federationHTTPTimeout bounds every announce/pull request to a seed, so a
+// single unreachable or slow seed can't stall the federation loop (and
+// thus every other seed's announce/pull, plus the registry Prune/Save that
+// follows) for the OS-level TCP/TLS timeout.
+const federationHTTPTimeout = 10 * time.Second
+
+var federationHTTPClient = &http.Client{Timeout: federationHTTPTimeout}
+
+// federationEnabled is true once main() has started federationLoop with
+// at least one seed. The registry and /nodes stay available either way
+// (see main's comment on NewRegistry), but writes to it over the API
+// are only trusted once this instance has opted into federating.
+var federationEnabled bool
+
+// trustedSeedIPs holds the resolved addresses of this instance's
+// configured -federate seeds. A NodeInfo's signature only proves its
+// sender generated a matching keypair, not that the sender is a peer
+// we actually chose to federate with, so apiNodesHandler additionally
+// requires that unsolicited POSTs come from one of these addresses.
+var trustedSeedIPs []net.IP
+
+// resolveSeedIPs resolves every seed URL's host to its IP addresses,
+// so apiNodesHandler can check a POST's remote address against them
+// without re-resolving (and re-paying DNS, for seeds given as
+// hostnames) on every request.
+func resolveSeedIPs(seeds []string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, seed := range seeds {
+		u, err := url.Parse(seed)
+		if err != nil {
+			return nil, fmt.Errorf("parse seed %q: %w", seed, err)
+		}
+		addrs, err := net.LookupIP(u.Hostname())
+		if err != nil {
+			return nil, fmt.Errorf("resolve seed %q: %w", seed, err)
+		}
+		ips = append(ips, addrs...)
+	}
+	return ips, nil
+}
+
+// isTrustedSeed reports whether remoteAddr (as seen on an *http.Request)
+// belongs to one of trustedSeedIPs.
+func isTrustedSeed(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, trusted := range trustedSeedIPs {
+		if trusted.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxRegistryNodes bounds how many distinct public keys the registry
+// will hold, so a flood of POSTs each carrying a freshly generated
+// keypair can't grow it (and the file Save persists it to) without
+// bound.
+const maxRegistryNodes = 1000
+
+// maxNodeInfoBytes bounds a single federation POST body. NodeInfo is a
+// handful of short fields plus a fixed-size signature, so this is
+// comfortably more than any legitimate announcement needs.
+const maxNodeInfoBytes = 4 << 10
+
+// NodeInfo is a single entry in the federation registry: one instance
+// of yggpub announcing itself.
+type NodeInfo struct {
+	NodeName  string    `json:"nodename"`
+	IPv6      string    `json:"ipv6"`
+	Subnet    string    `json:"subnet"`
+	Version   string    `json:"version"`
+	Contact   string    `json:"contact"`
+	PublicKey string    `json:"public_key"` // hex-encoded ed25519 public key, used to verify Signature
+	Signature string    `json:"signature"`  // hex-encoded ed25519 signature over the fields above
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// signingPayload returns the canonical bytes that PublicKey signs over:
+// every field except Signature and LastSeen, which the receiver sets
+// itself on arrival.
+func (n NodeInfo) signingPayload() []byte {
+	return []byte(fmt.Sprintf("%s|%s|%s|%s|%s|%s", n.NodeName, n.IPv6, n.Subnet, n.Version, n.Contact, n.PublicKey))
+}
+
+// sign sets n.Signature using priv. priv must correspond to n.PublicKey.
+func (n *NodeInfo) sign(priv ed25519.PrivateKey) {
+	n.Signature = hex.EncodeToString(ed25519.Sign(priv, n.signingPayload()))
+}
+
+// verify reports whether n.Signature is a valid ed25519 signature over
+// n's fields, made by n.PublicKey.
+func (n NodeInfo) verify() bool {
+	pub, err := hex.DecodeString(n.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return false
+	}
+	sig, err := hex.DecodeString(n.Signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(pub), n.signingPayload(), sig)
+}
+
+// Registry is the set of known yggpub instances, keyed by public key so
+// that a node can update its own entry without colliding with another
+// node that happens to share a name.
+type Registry struct {
+	path string
+
+	mu    sync.RWMutex
+	nodes map[string]NodeInfo
+}
+
+// NewRegistry loads a Registry from path if it exists, or starts an
+// empty one that will be created at path on the first Save.
+func NewRegistry(path string) (*Registry, error) {
+	reg := &Registry{path: path, nodes: make(map[string]NodeInfo)}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return reg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read node registry: %w", err)
+	}
+	var nodes []NodeInfo
+	if err := json.Unmarshal(b, &nodes); err != nil {
+		return nil, fmt.Errorf("parse node registry: %w", err)
+	}
+	for _, n := range nodes {
+		reg.nodes[n.PublicKey] = n
+	}
+	return reg, nil
+}
+
+// Upsert verifies info's signature and, if valid, records it as the
+// current entry for its public key, unless the existing entry for that
+// key has the same or a later LastSeen, in which case the existing
+// entry is kept. It reports whether info was accepted; a kept existing
+// entry still counts as accepted. A new key (one the registry hasn't seen before) is
+// rejected once the registry already holds maxRegistryNodes entries,
+// so a public key flood can't grow it without bound; an update to an
+// existing key is always allowed through.
+func (reg *Registry) Upsert(info NodeInfo) bool {
+	if !info.verify() {
+		return false
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	existing, ok := reg.nodes[info.PublicKey]
+	if ok && !info.LastSeen.After(existing.LastSeen) {
+		return true
+	}
+	if !ok && len(reg.nodes) >= maxRegistryNodes {
+		return false
+	}
+	reg.nodes[info.PublicKey] = info
+	return true
+}
+
+// Prune removes entries that haven't been seen within maxAge.
+func (reg *Registry) Prune(maxAge time.Duration) {
+	cutoff := time.Now().Add(-maxAge)
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for key, n := range reg.nodes {
+		if n.LastSeen.Before(cutoff) {
+			delete(reg.nodes, key)
+		}
+	}
+}
+
+// List returns a stable-ordered snapshot of every known node.
+func (reg *Registry) List() []NodeInfo {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	out := make([]NodeInfo, 0, len(reg.nodes))
+	for _, n := range reg.nodes {
+		out = append(out, n)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].NodeName != out[j].NodeName {
+			return out[i].NodeName < out[j].NodeName
+		}
+		return out[i].PublicKey < out[j].PublicKey
+	})
+	return out
+}
+
+// Save persists the registry to disk so a restart doesn't lose known
+// peers.
+func (reg *Registry) Save() error {
+	reg.mu.RLock()
+	nodes := make([]NodeInfo, 0, len(reg.nodes))
+	for _, n := range reg.nodes {
+		nodes = append(nodes, n)
+	}
+	reg.mu.RUnlock()
+
+	b, err := json.MarshalIndent(nodes, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal node registry: %w", err)
+	}
+	return ioutil.WriteFile(reg.path, b, 0600)
+}
+
+// loadOrCreateFederationKey reads an ed25519 private key from path, or
+// generates and persists a new one if it doesn't exist yet.
+func loadOrCreateFederationKey(path string) (ed25519.PrivateKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err == nil {
+		if len(b) != ed25519.PrivateKeySize {
+			return nil, fmt.Errorf("federation key %s has wrong length", path)
+		}
+		return ed25519.PrivateKey(b), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read federation key: %w", err)
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate federation key: %w", err)
+	}
+	if err := ioutil.WriteFile(path, priv, 0600); err != nil {
+		return nil, fmt.Errorf("write federation key: %w", err)
+	}
+	return priv, nil
+}
+
+// selfNodeInfo builds and signs this instance's current NodeInfo from
+// the latest admin snapshot.
+func selfNodeInfo(priv ed25519.PrivateKey, snap *Snapshot) NodeInfo {
+	info := NodeInfo{
+		NodeName:  *nodename,
+		IPv6:      snap.Self.IPAddress,
+		Subnet:    snap.Self.Subnet,
+		Version:   snap.Self.BuildVersion,
+		Contact:   *federationcontact,
+		PublicKey: hex.EncodeToString(priv.Public().(ed25519.PublicKey)),
+		LastSeen:  time.Now(),
+	}
+	info.sign(priv)
+	return info
+}
+
+// federationLoop periodically announces this instance to every seed
+// and pulls each seed's registry, merging accepted entries into reg.
+func federationLoop(priv ed25519.PrivateKey, reg *Registry, seeds []string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		runFederationRound(priv, reg, seeds)
+		<-ticker.C
+	}
+}
+
+func runFederationRound(priv ed25519.PrivateKey, reg *Registry, seeds []string) {
+	// Read the shared snapshot cache rather than dialling the admin
+	// socket ourselves, so the federation loop's interval doesn't turn
+	// into a second uncoordinated poller racing snapshot.go's for the
+	// same socket.
+	snap, _, err := currentSnapshot()
+	if snap == nil {
+		if err != nil {
+			log.Println("federation round:", err)
+		}
+		return
+	}
+
+	self := selfNodeInfo(priv, snap)
+	reg.Upsert(self)
+
+	body, err := json.Marshal(self)
+	if err != nil {
+		log.Println("federation round: marshal self:", err)
+		return
+	}
+
+	for _, seed := range seeds {
+		seed = strings.TrimRight(seed, "/")
+		if _, err := federationHTTPClient.Post(seed+"/api/v1/nodes", "application/json", strings.NewReader(string(body))); err != nil {
+			log.Println("federation announce to", seed, "failed:", err)
+		}
+
+		resp, err := federationHTTPClient.Get(seed + "/api/v1/nodes")
+		if err != nil {
+			log.Println("federation pull from", seed, "failed:", err)
+			continue
+		}
+		var nodes []NodeInfo
+		err = json.NewDecoder(resp.Body).Decode(&nodes)
+		resp.Body.Close()
+		if err != nil {
+			log.Println("federation pull from", seed, "decode failed:", err)
+			continue
+		}
+		for _, n := range nodes {
+			reg.Upsert(n)
+		}
+	}
+
+	reg.Prune(30 * time.Minute)
+	if err := reg.Save(); err != nil {
+		log.Println("federation round: save registry:", err)
+	}
+}
+
+// splitSeeds parses a comma-separated -federate flag value into a
+// cleaned list of seed URLs.
+func splitSeeds(flagValue string) []string {
+	var seeds []string
+	for _, s := range strings.Split(flagValue, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			seeds = append(seeds, s)
+		}
+	}
+	return seeds
+}
+
+func apiNodesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		serveJSON(w, r, registry.List(), "")
+	case http.MethodPost:
+		// A valid signature only proves a POST came from whoever holds
+		// the matching private key, which any caller can generate for
+		// itself -- it doesn't prove the caller is a trusted federation
+		// peer. So on a non-federating instance, where nothing has ever
+		// chosen to announce to (or accept announcements from) anyone,
+		// reject writes outright rather than let any visitor seed the
+		// registry that /nodes renders.
+		if !federationEnabled {
+			http.Error(w, "federation is not enabled", http.StatusNotFound)
+			return
+		}
+		// Even with federation on, a signature alone doesn't prove the
+		// sender is one of our configured seeds, so only accept writes
+		// from their resolved addresses.
+		if !isTrustedSeed(r.RemoteAddr) {
+			http.Error(w, "sender is not a configured federation seed", http.StatusForbidden)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxNodeInfoBytes)
+		var info NodeInfo
+		if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		info.LastSeen = time.Now()
+		if !registry.Upsert(info) {
+			http.Error(w, "invalid signature or node registry full", http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func nodesHandler(w http.ResponseWriter, r *http.Request) {
+	if wantsJSON(r) {
+		apiNodesHandler(w, r)
+		return
+	}
+
+	var str strings.Builder
+	str.WriteString("<table class='nodes'>\n<tr><th>Node</th><th>IPv6</th><th>Subnet</th><th>Version</th><th>Last seen</th></tr>\n")
+	for _, n := range registry.List() {
+		str.WriteString(fmt.Sprintf("<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>\n",
+			html.EscapeString(n.NodeName), html.EscapeString(n.IPv6), html.EscapeString(n.Subnet),
+			html.EscapeString(n.Version), n.LastSeen.Format(time.RFC3339)))
+	}
+	str.WriteString("</table>\n")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, str.String())
+}
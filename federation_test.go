@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNodeInfoSignVerifyRoundTrip(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	n := NodeInfo{
+		NodeName:  "test-node",
+		IPv6:      "200::1",
+		Subnet:    "200::/7",
+		Version:   "0.5.14",
+		Contact:   "mailto:test@example.com",
+		PublicKey: hex.EncodeToString(pub),
+		LastSeen:  time.Now(),
+	}
+	n.sign(priv)
+
+	if !n.verify() {
+		t.Fatal("expected signature to verify")
+	}
+}
+
+func TestNodeInfoVerifyRejectsTamperedField(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	n := NodeInfo{
+		NodeName:  "test-node",
+		IPv6:      "200::1",
+		Subnet:    "200::/7",
+		Version:   "0.5.14",
+		PublicKey: hex.EncodeToString(pub),
+		LastSeen:  time.Now(),
+	}
+	n.sign(priv)
+
+	n.IPv6 = "200::2"
+	if n.verify() {
+		t.Fatal("expected signature to fail after tampering with a signed field")
+	}
+}
+
+func TestNodeInfoVerifyRejectsBadPublicKey(t *testing.T) {
+	n := NodeInfo{
+		NodeName:  "test-node",
+		PublicKey: "not-hex",
+		Signature: "also-not-hex",
+	}
+	if n.verify() {
+		t.Fatal("expected verify to fail for an undecodable public key")
+	}
+}
+
+func TestNodesHandlerEscapesNodeFields(t *testing.T) {
+	orig := registry
+	defer func() { registry = orig }()
+
+	registry = &Registry{nodes: map[string]NodeInfo{
+		"k": {NodeName: "<script>alert(1)</script>", IPv6: "200::1", Subnet: "200::/7", Version: "0.5.14"},
+	}}
+
+	r := httptest.NewRequest(http.MethodGet, "/nodes", nil)
+	w := httptest.NewRecorder()
+	nodesHandler(w, r)
+
+	if body := w.Body.String(); strings.Contains(body, "<script>") {
+		t.Errorf("nodesHandler did not escape a node field, body contained raw <script>: %s", body)
+	}
+}
+
+func TestAPINodesHandlerRejectsPostWhenFederationDisabled(t *testing.T) {
+	origEnabled, origRegistry := federationEnabled, registry
+	defer func() { federationEnabled, registry = origEnabled, origRegistry }()
+
+	federationEnabled = false
+	registry = &Registry{nodes: map[string]NodeInfo{}}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/nodes", strings.NewReader("{}"))
+	w := httptest.NewRecorder()
+	apiNodesHandler(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestAPINodesHandlerRejectsPostFromUntrustedSender(t *testing.T) {
+	origEnabled, origRegistry, origTrusted := federationEnabled, registry, trustedSeedIPs
+	defer func() { federationEnabled, registry, trustedSeedIPs = origEnabled, origRegistry, origTrusted }()
+
+	federationEnabled = true
+	registry = &Registry{nodes: map[string]NodeInfo{}}
+	trustedSeedIPs = []net.IP{net.ParseIP("203.0.113.1")} // not this request's remote address
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/nodes", strings.NewReader("{}"))
+	w := httptest.NewRecorder()
+	apiNodesHandler(w, r)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestAPINodesHandlerAcceptsPostFromTrustedSender(t *testing.T) {
+	origEnabled, origRegistry, origTrusted := federationEnabled, registry, trustedSeedIPs
+	defer func() { federationEnabled, registry, trustedSeedIPs = origEnabled, origRegistry, origTrusted }()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	info := NodeInfo{NodeName: "seed", PublicKey: hex.EncodeToString(pub), LastSeen: time.Now()}
+	info.sign(priv)
+	body, err := json.Marshal(info)
+	if err != nil {
+		t.Fatalf("marshal node info: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/nodes", bytes.NewReader(body))
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		t.Fatalf("split remote addr %q: %v", r.RemoteAddr, err)
+	}
+
+	federationEnabled = true
+	registry = &Registry{nodes: map[string]NodeInfo{}}
+	trustedSeedIPs = []net.IP{net.ParseIP(host)}
+
+	w := httptest.NewRecorder()
+	apiNodesHandler(w, r)
+
+	if w.Code != http.StatusAccepted {
+		t.Errorf("status = %d, want %d (body: %s)", w.Code, http.StatusAccepted, w.Body.String())
+	}
+}
+
+func TestRegistryUpsertRejectsNewKeysPastCapacity(t *testing.T) {
+	reg := &Registry{nodes: make(map[string]NodeInfo)}
+	for i := 0; i < maxRegistryNodes; i++ {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+		n := NodeInfo{PublicKey: hex.EncodeToString(pub)}
+		n.sign(priv)
+		if !reg.Upsert(n) {
+			t.Fatalf("Upsert of node %d should have been accepted under capacity", i)
+		}
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	overflow := NodeInfo{PublicKey: hex.EncodeToString(pub)}
+	overflow.sign(priv)
+	if reg.Upsert(overflow) {
+		t.Fatal("expected Upsert of a new key past capacity to be rejected")
+	}
+	if len(reg.nodes) != maxRegistryNodes {
+		t.Fatalf("len(reg.nodes) = %d, want %d", len(reg.nodes), maxRegistryNodes)
+	}
+}
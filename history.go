@@ -0,0 +1,367 @@
+package main
+
+// history.go keeps a short rolling time-series of peer bandwidth so the
+// HTML page can show sparklines and the API can serve rate history,
+// rather than only ever showing a single point-in-time slice.
+//
+// Samples are derived from the raw, ever-increasing tx/rx counters the
+// admin socket reports: each time a new counter reading comes in, we
+// turn the delta since the last reading into a bytes-per-second rate
+// and, no more often than once per resolution, append it to that peer's
+// ring buffer.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// rateSample is one point of peer (or aggregate) throughput. It
+// marshals as a 3-element JSON array, [timestamp, tx_bps, rx_bps], to
+// match the documented history API shape.
+type rateSample struct {
+	Time  time.Time
+	TXBps float64
+	RXBps float64
+}
+
+func (s rateSample) MarshalJSON() ([]byte, error) {
+	return json.Marshal([3]interface{}{s.Time.Unix(), s.TXBps, s.RXBps})
+}
+
+// renderSparkline renders a small inline SVG line of combined tx+rx
+// throughput from a set of rate samples, for embedding next to a
+// peer's donut chart in the HTML template.
+func renderSparkline(samples []rateSample) string {
+	const width, height = 120, 24
+	if len(samples) < 2 {
+		return "<div class='sparkline-empty'>Not enough history yet</div>\n"
+	}
+
+	var max float64
+	for _, s := range samples {
+		if v := s.TXBps + s.RXBps; v > max {
+			max = v
+		}
+	}
+	if max == 0 {
+		max = 1
+	}
+
+	var points strings.Builder
+	for i, s := range samples {
+		x := float64(i) / float64(len(samples)-1) * width
+		y := height - (s.TXBps+s.RXBps)/max*height
+		if i > 0 {
+			points.WriteString(" ")
+		}
+		fmt.Fprintf(&points, "%.1f,%.1f", x, y)
+	}
+
+	return fmt.Sprintf("<svg class='sparkline' width='%d' height='%d' viewBox='0 0 %d %d'><polyline fill='none' stroke='currentColor' stroke-width='1' points='%s'/></svg>\n",
+		width, height, width, height, points.String())
+}
+
+// HistoryStore records bandwidth counters and serves back rate history.
+// It's implemented by an in-memory ring buffer by default, or by a
+// SQLite-backed store when persistence across restarts is wanted.
+type HistoryStore interface {
+	// Record stores a reading of cumulative tx/rx bytes for a peer,
+	// keyed by its public key, at time t.
+	Record(pubkey string, t time.Time, txBytes, rxBytes uint64)
+	// RecordTotal stores a reading of cumulative tx/rx bytes across all
+	// peers at time t.
+	RecordTotal(t time.Time, txBytes, rxBytes uint64)
+	// Series returns the rate samples recorded for a peer since the
+	// given time, oldest first.
+	Series(pubkey string, since time.Time) []rateSample
+	// TotalSeries returns the aggregate rate samples since the given
+	// time, oldest first.
+	TotalSeries(since time.Time) []rateSample
+	// Prune drops per-peer state that hasn't been recorded within the
+	// store's retention window, so peer churn on a long-running node
+	// doesn't grow it without bound.
+	Prune(now time.Time)
+}
+
+// newHistoryStore builds the configured HistoryStore: in-memory if
+// dbPath is empty, SQLite-backed otherwise.
+func newHistoryStore(retention, resolution time.Duration, dbPath string) (HistoryStore, error) {
+	if dbPath == "" {
+		return newMemoryHistoryStore(retention, resolution), nil
+	}
+	return newSQLiteHistoryStore(dbPath, retention, resolution)
+}
+
+// rate turns a counter delta into a bytes-per-second rate, treating a
+// counter that has gone backwards (e.g. a peer reconnecting) as zero
+// traffic rather than producing a bogus negative rate.
+func rate(cur, prev uint64, dt float64) float64 {
+	if dt <= 0 || cur < prev {
+		return 0
+	}
+	return float64(cur-prev) / dt
+}
+
+// counterTracker turns successive cumulative byte counters into
+// resolution-limited rate samples appended to a ring buffer. It's
+// shared by both the per-peer and the aggregate series.
+type counterTracker struct {
+	haveLast     bool
+	lastTX       uint64
+	lastRX       uint64
+	lastTime     time.Time
+	lastRecorded time.Time
+	ring         *ringBuffer
+}
+
+func newCounterTracker(capacity int) *counterTracker {
+	return &counterTracker{ring: newRingBuffer(capacity)}
+}
+
+// record turns a new cumulative counter reading into a rate sample and
+// appends it to the ring buffer, no more often than once per resolution.
+// It reports whether it actually appended a sample (and what that sample
+// was), since the ring buffer is capacity-bounded and a caller can't tell
+// "did this append" from its length once it's full.
+func (ct *counterTracker) record(t time.Time, tx, rx uint64, resolution time.Duration) (sample rateSample, appended bool) {
+	if ct.haveLast {
+		if dt := t.Sub(ct.lastTime).Seconds(); t.Sub(ct.lastRecorded) >= resolution {
+			sample = rateSample{Time: t, TXBps: rate(tx, ct.lastTX, dt), RXBps: rate(rx, ct.lastRX, dt)}
+			ct.ring.add(sample)
+			ct.lastRecorded = t
+			appended = true
+		}
+	} else {
+		ct.lastRecorded = t
+	}
+	ct.haveLast = true
+	ct.lastTX, ct.lastRX, ct.lastTime = tx, rx, t
+	return sample, appended
+}
+
+// ringBuffer is a fixed-capacity, time-ordered buffer of rateSamples;
+// once full, the oldest sample is dropped to make room for the newest.
+type ringBuffer struct {
+	mu      sync.Mutex
+	samples []rateSample
+	cap     int
+}
+
+func newRingBuffer(capacity int) *ringBuffer {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &ringBuffer{cap: capacity}
+}
+
+func (rb *ringBuffer) add(s rateSample) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.samples = append(rb.samples, s)
+	if len(rb.samples) > rb.cap {
+		rb.samples = rb.samples[len(rb.samples)-rb.cap:]
+	}
+}
+
+func (rb *ringBuffer) since(cutoff time.Time) []rateSample {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	out := make([]rateSample, 0, len(rb.samples))
+	for _, s := range rb.samples {
+		if !s.Time.Before(cutoff) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// memoryHistoryStore is the default HistoryStore: everything lives in
+// memory and is lost on restart.
+type memoryHistoryStore struct {
+	retention  time.Duration
+	resolution time.Duration
+	capacity   int
+
+	mu    sync.Mutex
+	peers map[string]*counterTracker
+	total *counterTracker
+}
+
+func newMemoryHistoryStore(retention, resolution time.Duration) *memoryHistoryStore {
+	capacity := int(retention / resolution)
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &memoryHistoryStore{
+		retention:  retention,
+		resolution: resolution,
+		capacity:   capacity,
+		peers:      make(map[string]*counterTracker),
+		total:      newCounterTracker(capacity),
+	}
+}
+
+func (m *memoryHistoryStore) Record(pubkey string, t time.Time, tx, rx uint64) {
+	m.record(pubkey, t, tx, rx)
+}
+
+// record is Record's unexported counterpart: it additionally reports
+// whether a sample was actually appended (and what it was), so
+// sqliteHistoryStore can persist exactly the samples the ring buffer
+// accepted instead of diffing its (capacity-bounded) length.
+func (m *memoryHistoryStore) record(pubkey string, t time.Time, tx, rx uint64) (rateSample, bool) {
+	m.mu.Lock()
+	ct, ok := m.peers[pubkey]
+	if !ok {
+		ct = newCounterTracker(m.capacity)
+		m.peers[pubkey] = ct
+	}
+	m.mu.Unlock()
+	return ct.record(t, tx, rx, m.resolution)
+}
+
+func (m *memoryHistoryStore) RecordTotal(t time.Time, tx, rx uint64) {
+	m.recordTotal(t, tx, rx)
+}
+
+func (m *memoryHistoryStore) recordTotal(t time.Time, tx, rx uint64) (rateSample, bool) {
+	return m.total.record(t, tx, rx, m.resolution)
+}
+
+func (m *memoryHistoryStore) Series(pubkey string, since time.Time) []rateSample {
+	m.mu.Lock()
+	ct, ok := m.peers[pubkey]
+	m.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return ct.ring.since(since)
+}
+
+func (m *memoryHistoryStore) TotalSeries(since time.Time) []rateSample {
+	return m.total.ring.since(since)
+}
+
+// Prune removes the tracker for any peer whose most recent counter
+// reading is older than now minus the retention window -- e.g. one
+// that dropped out of the peer list and was never recorded again.
+// Without this, m.peers grows for as long as the process runs, one
+// entry per distinct pubkey ever seen, regardless of retention.
+func (m *memoryHistoryStore) Prune(now time.Time) {
+	cutoff := now.Add(-m.retention)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for pubkey, ct := range m.peers {
+		if ct.lastTime.Before(cutoff) {
+			delete(m.peers, pubkey)
+		}
+	}
+}
+
+// sqliteHistoryStore persists rate samples to a SQLite database so
+// history survives a restart. It keeps the same in-memory counter
+// trackers and ring buffers for computing rates, appends every emitted
+// sample to the database as well, serves Series and TotalSeries from
+// the database, and prunes rows older than the retention window on
+// each write.
+type sqliteHistoryStore struct {
+	*memoryHistoryStore
+	db *sql.DB
+}
+
+func newSQLiteHistoryStore(path string, retention, resolution time.Duration) (*sqliteHistoryStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("open history database: %w", err)
+	}
+	schema := `
+CREATE TABLE IF NOT EXISTS peer_samples (
+	pubkey TEXT NOT NULL,
+	ts INTEGER NOT NULL,
+	tx_bps REAL NOT NULL,
+	rx_bps REAL NOT NULL
+);
+CREATE INDEX IF NOT EXISTS peer_samples_pubkey_ts ON peer_samples (pubkey, ts);
+CREATE TABLE IF NOT EXISTS total_samples (
+	ts INTEGER NOT NULL,
+	tx_bps REAL NOT NULL,
+	rx_bps REAL NOT NULL
+);
+`
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create history schema: %w", err)
+	}
+	return &sqliteHistoryStore{
+		memoryHistoryStore: newMemoryHistoryStore(retention, resolution),
+		db:                 db,
+	}, nil
+}
+
+func (s *sqliteHistoryStore) Record(pubkey string, t time.Time, tx, rx uint64) {
+	sample, ok := s.memoryHistoryStore.record(pubkey, t, tx, rx)
+	if !ok {
+		return
+	}
+	s.insertPeerSample(pubkey, sample)
+}
+
+func (s *sqliteHistoryStore) RecordTotal(t time.Time, tx, rx uint64) {
+	sample, ok := s.memoryHistoryStore.recordTotal(t, tx, rx)
+	if !ok {
+		return
+	}
+	s.insertTotalSample(sample)
+}
+
+func (s *sqliteHistoryStore) insertPeerSample(pubkey string, sample rateSample) {
+	if _, err := s.db.Exec("INSERT INTO peer_samples (pubkey, ts, tx_bps, rx_bps) VALUES (?, ?, ?, ?)",
+		pubkey, sample.Time.Unix(), sample.TXBps, sample.RXBps); err != nil {
+		return
+	}
+	cutoff := sample.Time.Add(-s.retention).Unix()
+	s.db.Exec("DELETE FROM peer_samples WHERE ts < ?", cutoff)
+}
+
+func (s *sqliteHistoryStore) insertTotalSample(sample rateSample) {
+	if _, err := s.db.Exec("INSERT INTO total_samples (ts, tx_bps, rx_bps) VALUES (?, ?, ?)",
+		sample.Time.Unix(), sample.TXBps, sample.RXBps); err != nil {
+		return
+	}
+	cutoff := sample.Time.Add(-s.retention).Unix()
+	s.db.Exec("DELETE FROM total_samples WHERE ts < ?", cutoff)
+}
+
+func (s *sqliteHistoryStore) Series(pubkey string, since time.Time) []rateSample {
+	return s.querySamples("SELECT ts, tx_bps, rx_bps FROM peer_samples WHERE pubkey = ? AND ts >= ? ORDER BY ts", pubkey, since.Unix())
+}
+
+func (s *sqliteHistoryStore) TotalSeries(since time.Time) []rateSample {
+	return s.querySamples("SELECT ts, tx_bps, rx_bps FROM total_samples WHERE ts >= ? ORDER BY ts", since.Unix())
+}
+
+func (s *sqliteHistoryStore) querySamples(query string, args ...interface{}) []rateSample {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var out []rateSample
+	for rows.Next() {
+		var ts int64
+		var sample rateSample
+		if err := rows.Scan(&ts, &sample.TXBps, &sample.RXBps); err != nil {
+			return out
+		}
+		sample.Time = time.Unix(ts, 0)
+		out = append(out, sample)
+	}
+	return out
+}
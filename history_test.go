@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRate(t *testing.T) {
+	cases := []struct {
+		name      string
+		cur, prev uint64
+		dt        float64
+		want      float64
+	}{
+		{"steady increase", 2000, 1000, 10, 100},
+		{"zero elapsed", 1000, 500, 0, 0},
+		{"negative elapsed", 1000, 500, -1, 0},
+		{"counter went backwards", 500, 1000, 10, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := rate(c.cur, c.prev, c.dt); got != c.want {
+				t.Errorf("rate(%d, %d, %v) = %v, want %v", c.cur, c.prev, c.dt, got, c.want)
+			}
+		})
+	}
+}
+
+func TestRingBufferDropsOldestOnceFull(t *testing.T) {
+	rb := newRingBuffer(3)
+	base := time.Unix(1000, 0)
+	for i := 0; i < 5; i++ {
+		rb.add(rateSample{Time: base.Add(time.Duration(i) * time.Second)})
+	}
+
+	samples := rb.since(time.Time{})
+	if len(samples) != 3 {
+		t.Fatalf("len(samples) = %d, want 3", len(samples))
+	}
+	if samples[0].Time != base.Add(2*time.Second) {
+		t.Errorf("oldest surviving sample = %v, want the 3rd one added", samples[0].Time)
+	}
+}
+
+// TestCounterTrackerRecordReportsAppendPastCapacity guards against the
+// regression where sqliteHistoryStore decided whether to persist a sample
+// by diffing ring buffer lengths before/after a record call: once the
+// ring fills, its length stops changing and that comparison never fires
+// again. record's own return value must keep reporting appends
+// correctly even once the buffer is full.
+func TestCounterTrackerRecordReportsAppendPastCapacity(t *testing.T) {
+	ct := newCounterTracker(2)
+	resolution := time.Second
+	base := time.Unix(1000, 0)
+
+	// First call only establishes a baseline; it shouldn't append.
+	if _, appended := ct.record(base, 0, 0, resolution); appended {
+		t.Fatal("first record() call should not append (no prior reading to diff against)")
+	}
+
+	for i := 1; i <= 10; i++ {
+		tm := base.Add(time.Duration(i) * time.Second)
+		sample, appended := ct.record(tm, uint64(i*100), uint64(i*50), resolution)
+		if !appended {
+			t.Fatalf("record() call %d should have appended a sample past one resolution interval", i)
+		}
+		if sample.Time != tm {
+			t.Errorf("record() call %d returned sample for %v, want %v", i, sample.Time, tm)
+		}
+	}
+
+	if got := len(ct.ring.since(time.Time{})); got != 2 {
+		t.Fatalf("ring buffer length = %d, want capacity 2", got)
+	}
+}
+
+func TestMemoryHistoryStorePrunesStalePeers(t *testing.T) {
+	m := newMemoryHistoryStore(time.Hour, time.Minute)
+	base := time.Unix(1000, 0)
+
+	m.Record("stale", base, 0, 0)
+	m.Record("fresh", base, 0, 0)
+
+	later := base.Add(2 * time.Hour)
+	m.Record("fresh", later, 1000, 500)
+	m.Prune(later)
+
+	m.mu.Lock()
+	_, staleStillTracked := m.peers["stale"]
+	_, freshStillTracked := m.peers["fresh"]
+	m.mu.Unlock()
+
+	if staleStillTracked {
+		t.Error("Prune should have dropped the tracker for a peer not recorded within retention")
+	}
+	if !freshStillTracked {
+		t.Error("Prune should not have dropped the tracker for a peer recorded within retention")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+// metrics.go exports a Prometheus-compatible /metrics endpoint. Scrapes
+// are served from a Snapshot that's refreshed by a background poller on
+// its own interval, so a scrape never blocks on (or triggers) a fresh
+// admin socket round trip.
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var metricsinterval *time.Duration
+
+var (
+	peerBytesSent = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "yggpub_peer_bytes_sent_total",
+		Help: "Bytes sent to each connected peer.",
+	}, []string{"ipv6", "direction", "port"})
+
+	peerBytesRecvd = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "yggpub_peer_bytes_recvd_total",
+		Help: "Bytes received from each connected peer.",
+	}, []string{"ipv6", "direction", "port"})
+
+	peerUptime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "yggpub_peer_uptime_seconds",
+		Help: "Seconds since each connected peer was established.",
+	}, []string{"ipv6", "direction", "port"})
+
+	peersTotal = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "yggpub_peers_total",
+		Help: "Number of directly connected peers.",
+	})
+
+	treeEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "yggpub_tree_entries",
+		Help: "Number of entries in the local node's spanning tree view.",
+	})
+
+	selfRoutingEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "yggpub_self_routing_entries",
+		Help: "Number of routing table entries known to this node.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(peerBytesSent, peerBytesRecvd, peerUptime, peersTotal, treeEntries, selfRoutingEntries)
+}
+
+// pollMetrics refreshes the Prometheus collectors from the shared
+// snapshot cache on the given interval for as long as the process runs.
+// It reads currentSnapshot() rather than dialling the admin socket
+// itself, so a scrape interval independent of -pollinterval doesn't
+// turn into a second, uncoordinated poller hammering (or hanging on)
+// the same socket as snapshot.go's.
+func pollMetrics(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		updateMetrics()
+		<-ticker.C
+	}
+}
+
+// updateMetrics takes the current cached Snapshot and applies it to the
+// Prometheus collectors.
+func updateMetrics() {
+	snap, _, err := currentSnapshot()
+	if snap == nil {
+		if err != nil {
+			log.Println("metrics poll:", err)
+		}
+		return
+	}
+
+	peerBytesSent.Reset()
+	peerBytesRecvd.Reset()
+	peerUptime.Reset()
+	for _, peer := range snap.Peers.Peers {
+		direction := "outbound"
+		if peer.Inbound {
+			direction = "inbound"
+		}
+		labels := prometheus.Labels{
+			"ipv6":      peer.IPAddress,
+			"direction": direction,
+			"port":      fmt.Sprintf("%d", peer.Port),
+		}
+		peerBytesSent.With(labels).Set(float64(peer.TXBytes))
+		peerBytesRecvd.With(labels).Set(float64(peer.RXBytes))
+		peerUptime.With(labels).Set(peer.Uptime)
+	}
+
+	peersTotal.Set(float64(len(snap.Peers.Peers)))
+	treeEntries.Set(float64(len(snap.Tree.Tree)))
+	selfRoutingEntries.Set(float64(snap.Self.RoutingEntries))
+}
@@ -0,0 +1,56 @@
+package main
+
+// model.go holds the in-memory model shared by every presenter (the
+// HTML template and the JSON API) so that they never disagree about
+// what the node currently looks like.
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
+)
+
+// Snapshot is a single round of admin socket queries, assembled into one
+// value that every presenter renders from.
+type Snapshot struct {
+	Self  *admin.GetSelfResponse  `json:"self"`
+	Peers *admin.GetPeersResponse `json:"peers"`
+	Tree  *admin.GetTreeResponse  `json:"tree"`
+	Paths *admin.GetPathsResponse `json:"paths"`
+}
+
+// fetchSnapshot queries the admin socket for everything the HTTP layer
+// might need to render and assembles it into a single Snapshot.
+func fetchSnapshot(c *AdminClient) (*Snapshot, error) {
+	self, err := c.GetSelf()
+	if err != nil {
+		return nil, fmt.Errorf("getSelf: %w", err)
+	}
+	peers, err := c.GetPeers()
+	if err != nil {
+		return nil, fmt.Errorf("getPeers: %w", err)
+	}
+	tree, err := c.GetTree()
+	if err != nil {
+		return nil, fmt.Errorf("getTree: %w", err)
+	}
+	paths, err := c.GetPaths()
+	if err != nil {
+		return nil, fmt.Errorf("getPaths: %w", err)
+	}
+	return &Snapshot{Self: self, Peers: peers, Tree: tree, Paths: paths}, nil
+}
+
+// ETag returns a content hash of the snapshot suitable for use as an
+// HTTP ETag, so clients can skip re-downloading data that hasn't
+// changed since their last request.
+func (s *Snapshot) ETag() string {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return fmt.Sprintf(`"%x"`, sum[:8])
+}
@@ -0,0 +1,102 @@
+package main
+
+// snapshot.go maintains a single, atomically-swappable Snapshot that's
+// refreshed by a background poller instead of being fetched fresh on
+// every HTTP request. That way a slow or hung admin socket never stalls
+// a page load or a scraper, and handlers only ever do a lock-free read.
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// cachedSnapshot pairs a Snapshot with when it was fetched (and
+// whatever error the fetch returned), so readers can judge staleness.
+type cachedSnapshot struct {
+	snap      *Snapshot
+	fetchedAt time.Time
+	err       error
+}
+
+var snapshotCache atomic.Value // holds *cachedSnapshot
+
+// pollSnapshot refreshes the cached snapshot on the given interval for
+// as long as the process runs.
+func pollSnapshot(c *AdminClient, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		refreshSnapshot(c)
+		<-ticker.C
+	}
+}
+
+func refreshSnapshot(c *AdminClient) {
+	snap, err := fetchSnapshot(c)
+	now := time.Now()
+	if err != nil {
+		log.Println("snapshot poll:", err)
+		// A failed poll keeps serving the last good snapshot: fetchedAt
+		// stays put so its age keeps growing, and it's healthzHandler's
+		// staleness threshold (not this one failed attempt) that decides
+		// when to report unhealthy. Other handlers keep serving the last
+		// good snapshot whatever its age.
+		if prev, _ := snapshotCache.Load().(*cachedSnapshot); prev != nil && prev.snap != nil {
+			snapshotCache.Store(&cachedSnapshot{snap: prev.snap, fetchedAt: prev.fetchedAt, err: err})
+			return
+		}
+		snapshotCache.Store(&cachedSnapshot{snap: nil, fetchedAt: now, err: err})
+		return
+	}
+	snapshotCache.Store(&cachedSnapshot{snap: snap, fetchedAt: now, err: err})
+
+	if snap != nil && history != nil {
+		var totalTX, totalRX uint64
+		for _, peer := range snap.Peers.Peers {
+			tx, rx := uint64(peer.TXBytes), uint64(peer.RXBytes)
+			history.Record(peer.PublicKey, now, tx, rx)
+			totalTX += tx
+			totalRX += rx
+		}
+		history.RecordTotal(now, totalTX, totalRX)
+		history.Prune(now)
+	}
+}
+
+// currentSnapshot returns the most recently fetched good Snapshot (nil
+// if none), its age, and the error from the latest poll, if that poll
+// failed. It never touches the admin socket.
+func currentSnapshot() (*Snapshot, time.Duration, error) {
+	v, _ := snapshotCache.Load().(*cachedSnapshot)
+	if v == nil {
+		return nil, 0, fmt.Errorf("no snapshot has been polled yet")
+	}
+	return v.snap, time.Since(v.fetchedAt), v.err
+}
+
+// healthzHandler reports 200 while the cached snapshot is fresher than
+// staleness, or 503 once it's gone stale (or was never fetched). A poll
+// error alone isn't fatal as long as the last good snapshot is still
+// within staleness; see refreshSnapshot.
+func healthzHandler(staleness time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snap, age, err := currentSnapshot()
+		if snap == nil {
+			msg := "snapshot unavailable"
+			if err != nil {
+				msg += ": " + err.Error()
+			}
+			http.Error(w, msg, http.StatusServiceUnavailable)
+			return
+		}
+		if age > staleness {
+			http.Error(w, fmt.Sprintf("snapshot is stale (age %s)", age), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "ok")
+	}
+}
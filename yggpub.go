@@ -1,23 +1,26 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/dustin/go-humanize"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var nodename *string
 var listenaddr *string
 var adminaddr *string
+var admclient *AdminClient
+var federationcontact *string
+var registry *Registry
+var history HistoryStore
 
 func main() {
 	// Get the default hostname
@@ -30,13 +33,77 @@ func main() {
 	nodename = flag.String("nodename", hostname, "specify the friendly name of the node")
 	adminaddr = flag.String("adminaddr", "unix:///var/run/yggdrasil.sock", "path to the admin socket")
 	listenaddr = flag.String("listenaddr", "[::]:80", "address and port to listen on")
+	metricsinterval = flag.Duration("metricsinterval", 10*time.Second, "how often to refresh Prometheus metrics from the cached snapshot")
+	federate := flag.String("federate", "", "comma-separated list of seed yggpub URLs to federate with")
+	federationcontact = flag.String("federationcontact", "", "contact details to announce to federation seeds")
+	noderegistry := flag.String("noderegistry", "yggpub_nodes.json", "path to persist the federation node registry")
+	federationkey := flag.String("federationkey", "yggpub_federation.key", "path to this node's federation signing key")
+	federateinterval := flag.Duration("federateinterval", 5*time.Minute, "how often to announce to and pull from federation seeds")
+	pollinterval := flag.Duration("pollinterval", 5*time.Second, "how often to poll the admin socket for the cached snapshot")
+	staleness := flag.Duration("staleness", 30*time.Second, "how old the cached snapshot can get before /healthz reports unhealthy")
+	historyretention := flag.Duration("historyretention", 24*time.Hour, "how long to keep peer bandwidth history for")
+	historyresolution := flag.Duration("historyresolution", time.Minute, "how often to record a peer bandwidth history sample")
+	historydb := flag.String("historydb", "", "path to a SQLite database to persist bandwidth history in (default: in-memory only)")
 	flag.Parse()
 
-	// Set up the HTML handlers
+	// Set up the admin socket client
+	admclient, err = NewAdminClient(*adminaddr)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// Set up bandwidth history storage before we start polling, since
+	// every poll tick feeds it a new sample
+	history, err = newHistoryStore(*historyretention, *historyresolution, *historydb)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// Start polling the admin socket in the background and keep an
+	// atomically-swappable snapshot for handlers to read lock-free
+	go pollSnapshot(admclient, *pollinterval)
+
+	// Set up the HTML and JSON API handlers
 	http.HandleFunc("/", handler)
 	http.HandleFunc("/style.css", filehandler)
 	http.HandleFunc("/chartist.min.css", filehandler)
 	http.HandleFunc("/chartist.min.js", filehandler)
+	http.HandleFunc("/api/v1/peers", apiPeersHandler)
+	http.HandleFunc("/api/v1/self", apiSelfHandler)
+	http.HandleFunc("/api/v1/tree", apiTreeHandler)
+	http.HandleFunc("/api/v1/paths", apiPathsHandler)
+	http.HandleFunc("/api/v1/status", apiStatusHandler)
+	http.HandleFunc("/api/v1/history", apiHistoryHandler)
+	http.HandleFunc("/api/v1/peers/", apiPeerHistoryHandler)
+	http.HandleFunc("/healthz", healthzHandler(*staleness))
+	http.Handle("/metrics", promhttp.Handler())
+
+	// Start refreshing Prometheus metrics from the shared snapshot cache
+	// in the background, independent of incoming HTTP requests
+	go pollMetrics(*metricsinterval)
+
+	// Set up the federation node registry, whether or not we're
+	// actively federating, so /nodes always has something to show
+	registry, err = NewRegistry(*noderegistry)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	http.HandleFunc("/nodes", nodesHandler)
+	http.HandleFunc("/api/v1/nodes", apiNodesHandler)
+
+	if seeds := splitSeeds(*federate); len(seeds) > 0 {
+		priv, err := loadOrCreateFederationKey(*federationkey)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		trustedSeedIPs, err = resolveSeedIPs(seeds)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		federationEnabled = true
+		log.Println("Federating with seeds:", strings.Join(seeds, ", "))
+		go federationLoop(priv, registry, seeds, *federateinterval)
+	}
 
 	// Output some stuff
 	log.Println("Using node name:", *nodename)
@@ -47,151 +114,78 @@ func main() {
 	log.Fatal(http.ListenAndServe(*listenaddr, nil))
 }
 
-type switchPortData struct {
-	ports   []string
-	txbytes uint64
-	rxbytes uint64
-	coords  string
-}
-
 func filehandler(w http.ResponseWriter, r *http.Request) {
 	// Load the file up and send it to the browser
 	tokens := strings.Split(r.URL.Path, "/")
 	b, err := ioutil.ReadFile(tokens[len(tokens)-1:][0])
 	if err != nil {
-		log.Fatalln(err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
 	}
 	w.Write(b)
 }
 
+// handler is the data-fetching layer for "/": it reads the cached
+// Snapshot and hands it off to whichever presenter the request asked
+// for, rather than dialling the admin socket itself.
 func handler(w http.ResponseWriter, r *http.Request) {
-	// Get the template HTML file
-	b, err := ioutil.ReadFile("template.html")
-	if err != nil {
-		log.Fatalln(err)
-	}
-
-	// Parse the admin URL
-	admin, err := url.Parse(*adminaddr)
-	if err != nil {
-		log.Fatalln(err)
+	snap, ok := snapshotOrError(w)
+	if !ok {
 		return
 	}
 
-	// Connect to the admin socket
-	var a string
-	if admin.Scheme == "unix" {
-		a = admin.Path
-	} else {
-		a = admin.Host
-	}
-	conn, err := net.Dial(admin.Scheme, a)
-	if err != nil {
-		log.Println(err)
+	if wantsJSON(r) {
+		serveJSON(w, r, snap, snap.ETag())
 		return
 	}
-	defer conn.Close()
 
-	// Create the request that we will send to the admin socket
-	m := make(map[string]interface{})
-	m["request"] = "getSwitchPeers"
-
-	// Marshal the request into JSON
-	j, err := json.Marshal(m)
-	if err != nil {
-		fmt.Fprintf(w, strings.Replace(string(b), "%PEERS%", "Unable to marshal JSON", -1))
-		return
-	}
-
-	// Write the JSON to the admin socket
-	conn.Write(j)
-
-	// Create a buffer for the response
-	buff := make([]byte, 65535)
-
-	// Check for a response from the admin socket
-	n, _ := conn.Read(buff)
-	if n == 0 {
-		fmt.Fprintf(w, strings.Replace(string(b), "%PEERS%", "No response from admin socket", -1))
-		return
-	}
+	presentHTML(w, snap)
+}
 
-	// Parse it back from JSON
-	err = json.Unmarshal(buff[:n], &m)
+// presentHTML renders a Snapshot into the HTML template.
+func presentHTML(w http.ResponseWriter, snap *Snapshot) {
+	// Get the template HTML file
+	b, err := ioutil.ReadFile("template.html")
 	if err != nil {
-		fmt.Fprintf(w, strings.Replace(string(b), "%PEERS%", "Unable to unmarshal JSON", -1))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Check if the response showed success
-	if m["status"].(string) != "success" {
-		fmt.Fprintf(w, strings.Replace(string(b), "%PEERS%", "Non-successful response", -1))
-		return
+	// Work out the total traffic across all peers so we can size the
+	// donut segments against it
+	var totalbytes uint64
+	for _, peer := range snap.Peers.Peers {
+		totalbytes += uint64(peer.TXBytes) + uint64(peer.RXBytes)
 	}
 
 	// Create the output buffer
 	var str strings.Builder
 
-	// Print peer information
-	response := m["response"].(map[string]interface{})
-	peers := response["switchpeers"].(map[string]interface{})
-
-	// Create a map of our data
-	peermap := make(map[string]switchPortData)
-
-	// Populate the peermap
-	var totalbytes uint64
-	for k, v := range peers {
-		peer := v.(map[string]interface{})
-		peerip := peer["ip"].(string)
-		if peerdata, ok := peermap[peerip]; ok {
-			tx, rx := uint64(peer["bytes_sent"].(float64)), uint64(peer["bytes_recvd"].(float64))
-			peerdata.txbytes += tx
-			peerdata.rxbytes += rx
-			peerdata.ports = append(peerdata.ports, k)
-			peermap[peerip] = peerdata
-			totalbytes += tx + rx
-		} else {
-			tx, rx := uint64(peer["bytes_sent"].(float64)), uint64(peer["bytes_recvd"].(float64))
-			peerdata := switchPortData{
-				txbytes: tx,
-				rxbytes: rx,
-				coords:  peer["coords"].(string),
-			}
-			peerdata.ports = append(peerdata.ports, k)
-			peermap[peer["ip"].(string)] = peerdata
-			totalbytes += tx + rx
-		}
-	}
-
 	// Render the results
 	count := 0
 	offset := uint64(0)
-	for ipv6, peer := range peermap {
-		var ports string
-		if len(peer.ports) > 1 {
-			ports = "switch ports " + strings.Join(peer.ports, ", ")
-		} else {
-			ports = "switch port " + peer.ports[0]
-		}
-		if peer.coords == "[]" {
-			peer.coords = "Root"
+	for _, peer := range snap.Peers.Peers {
+		direction := "Outbound"
+		if peer.Inbound {
+			direction = "Inbound"
 		}
+		sent, recvd := uint64(peer.TXBytes), uint64(peer.RXBytes)
 		str.WriteString("<div class='node'>\n")
 		str.WriteString(fmt.Sprintf("<div class='ct-chart ct-perfect-fourth' id='ct-%d'></div>\n", count))
 		str.WriteString(fmt.Sprintf("<script>\nnew Chartist.Pie('#ct-%d', { series: [%d, %d, %d, %d] }, { donut: true, donutWidth: 25, donutSolid: true, startbytes: 0, showLabel: false });\n</script>\n",
-			count, offset, peer.txbytes, peer.rxbytes, totalbytes-offset-peer.txbytes-peer.rxbytes))
-		str.WriteString(fmt.Sprintf("<div id='ipv6'>%s</div>\n", ipv6))
-		str.WriteString(fmt.Sprintf("<div>%s attached to %s</div>\n", peer.coords, ports))
-		str.WriteString(fmt.Sprintf("<div>%s sent</div>\n", humanize.Bytes(peer.txbytes)))
-		str.WriteString(fmt.Sprintf("<div>%s received</div>\n", humanize.Bytes(peer.rxbytes)))
+			count, offset, sent, recvd, totalbytes-offset-sent-recvd))
+		str.WriteString(fmt.Sprintf("<div id='ipv6'>%s</div>\n", peer.IPAddress))
+		str.WriteString(fmt.Sprintf("<div>%s, attached on port %d</div>\n", direction, peer.Port))
+		str.WriteString(fmt.Sprintf("<div>%s sent</div>\n", humanize.Bytes(sent)))
+		str.WriteString(fmt.Sprintf("<div>%s received</div>\n", humanize.Bytes(recvd)))
+		str.WriteString(renderSparkline(history.Series(peer.PublicKey, time.Now().Add(-time.Hour))))
 		str.WriteString("</div>\n")
 		count++
-		offset += peer.rxbytes + peer.txbytes
+		offset += sent + recvd
 	}
 
 	// No peers? Say that instead!
-	if len(peermap) == 0 {
+	if len(snap.Peers.Peers) == 0 {
 		str.WriteString("<div>There are no connected peers at this time.</div>")
 	}
 